@@ -0,0 +1,57 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+)
+
+// newAdminServer builds the admin HTTP server: Prometheus metrics plus
+// health/readiness probes, kept off the proxy port so that arbitrary
+// clients proxying through alpaca can't reach operational endpoints.
+func newAdminServer(ready func() bool) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz(ready))
+	return &http.Server{Handler: mux}
+}
+
+func handleMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	appMetrics.WritePrometheus(w)
+}
+
+// handleHealthz reports alpaca's process as alive. It does not depend on
+// any upstream state, since a dead-but-listening process is exactly what
+// this probe exists to catch.
+func handleHealthz(w http.ResponseWriter, req *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether alpaca is ready to usefully serve traffic:
+// the PAC script has been fetched and loaded successfully, and (if
+// Kerberos auth is configured) a ticket is currently present.
+func handleReadyz(ready func() bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !ready() {
+			http.Error(w, "not ready\n", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// authCacheTTL is how long a persisted proxyHost → auth method mapping is
+// trusted before it's re-probed from scratch, so that a method revoked
+// while alpaca wasn't running eventually gets rediscovered.
+const authCacheTTL = 7 * 24 * time.Hour
+
+// authCacheEntry is one persisted proxyHost → auth method mapping.
+type authCacheEntry struct {
+	Method   string    `json:"method"`
+	CachedAt time.Time `json:"cached_at"`
+}
+
+// authCache persists the proxyHost → auth method mapping that
+// multiAuthenticator learns at runtime, so that alpaca doesn't have to
+// re-probe every method against every proxy (and potentially trip account
+// lockouts on strict AD proxies) on every restart.
+type authCache struct {
+	path string
+	mu   sync.Mutex
+	data map[string]authCacheEntry
+}
+
+// loadAuthCache reads the auth cache state file (see authCacheStatePath),
+// discarding any entries older than authCacheTTL. Returns nil if the state
+// file's location can't be determined; multiAuthenticator falls back to
+// in-memory-only caching in that case.
+func loadAuthCache() *authCache {
+	path, err := authCacheStatePath()
+	if err != nil {
+		log.Printf("Auth cache disabled: %v", err)
+		return nil
+	}
+	c := &authCache{path: path, data: make(map[string]authCacheEntry)}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Could not read auth cache %s: %v", path, err)
+		}
+		return c
+	}
+	var stored map[string]authCacheEntry
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		log.Printf("Could not parse auth cache %s: %v", path, err)
+		return c
+	}
+	now := time.Now()
+	for host, entry := range stored {
+		if now.Sub(entry.CachedAt) < authCacheTTL {
+			c.data[host] = entry
+		}
+	}
+	return c
+}
+
+// entries returns a snapshot of proxyHost → method name for every
+// non-expired cache entry.
+func (c *authCache) entries() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]string, len(c.data))
+	for host, entry := range c.data {
+		out[host] = entry.Method
+	}
+	return out
+}
+
+// put records that method works for proxyHost and writes the cache
+// through to disk.
+func (c *authCache) put(proxyHost, method string) error {
+	c.mu.Lock()
+	c.data[proxyHost] = authCacheEntry{Method: method, CachedAt: time.Now()}
+	c.mu.Unlock()
+	return c.save()
+}
+
+// delete removes proxyHost from the cache and writes the cache through to
+// disk.
+func (c *authCache) delete(proxyHost string) error {
+	c.mu.Lock()
+	delete(c.data, proxyHost)
+	c.mu.Unlock()
+	return c.save()
+}
+
+func (c *authCache) save() error {
+	c.mu.Lock()
+	data, err := json.Marshal(c.data)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0o600)
+}
+
+// authCacheStatePath returns $XDG_STATE_HOME/alpaca/authcache.json,
+// falling back to ~/.local/state/alpaca/authcache.json when
+// XDG_STATE_HOME isn't set, per the XDG Base Directory spec.
+func authCacheStatePath() (string, error) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateHome = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateHome, "alpaca", "authcache.json"), nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadAuthCacheDropsExpiredEntries(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", dir)
+
+	stored := map[string]authCacheEntry{
+		"fresh.example.com":   {Method: "negotiate", CachedAt: time.Now().Add(-1 * time.Hour)},
+		"expired.example.com": {Method: "basic", CachedAt: time.Now().Add(-authCacheTTL - time.Hour)},
+	}
+	raw, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatalf("marshaling fixture: %v", err)
+	}
+	path := filepath.Join(dir, "alpaca", "authcache.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatalf("creating state dir: %v", err)
+	}
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatalf("writing fixture: %v", err)
+	}
+
+	c := loadAuthCache()
+	if c == nil {
+		t.Fatal("loadAuthCache returned nil")
+	}
+	entries := c.entries()
+	if got, want := entries["fresh.example.com"], "negotiate"; got != want {
+		t.Errorf("fresh.example.com = %q, want %q", got, want)
+	}
+	if _, ok := entries["expired.example.com"]; ok {
+		t.Error("expired.example.com should have been dropped as expired")
+	}
+	if len(entries) != 1 {
+		t.Errorf("len(entries) = %d, want 1", len(entries))
+	}
+}
+
+func TestLoadAuthCacheMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", dir)
+
+	c := loadAuthCache()
+	if c == nil {
+		t.Fatal("loadAuthCache returned nil")
+	}
+	if entries := c.entries(); len(entries) != 0 {
+		t.Errorf("entries() = %v, want empty", entries)
+	}
+}
+
+func TestAuthCachePutAndDeleteRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", dir)
+
+	c := loadAuthCache()
+	if err := c.put("proxy.example.com", "negotiate"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if got, want := c.entries()["proxy.example.com"], "negotiate"; got != want {
+		t.Errorf("entries()[proxy.example.com] = %q, want %q", got, want)
+	}
+
+	// A fresh load from disk should see the persisted entry.
+	reloaded := loadAuthCache()
+	if got, want := reloaded.entries()["proxy.example.com"], "negotiate"; got != want {
+		t.Errorf("after reload, entries()[proxy.example.com] = %q, want %q", got, want)
+	}
+
+	if err := c.delete("proxy.example.com"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok := c.entries()["proxy.example.com"]; ok {
+		t.Error("proxy.example.com should have been deleted")
+	}
+}
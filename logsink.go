@@ -0,0 +1,266 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogSink receives one jsonLogEntry per proxied request. Implementations
+// must be safe for concurrent use, since RequestLoggerJSON may log from
+// many requests at once.
+type LogSink interface {
+	Log(entry jsonLogEntry)
+}
+
+// newLogSinks parses a --log-sink flag value such as
+// "stdout,file:/var/log/alpaca.log,syslog,otlp:https://collector:4318/v1/logs" into
+// the LogSink implementations it describes.
+func newLogSinks(spec string) ([]LogSink, error) {
+	var sinks []LogSink
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kind, target, _ := strings.Cut(part, ":")
+		switch kind {
+		case "stdout":
+			sinks = append(sinks, &stdoutSink{})
+		case "file":
+			sink, err := newFileSink(target)
+			if err != nil {
+				return nil, fmt.Errorf("log-sink %q: %w", part, err)
+			}
+			sinks = append(sinks, sink)
+		case "syslog":
+			sink, err := newSyslogSink()
+			if err != nil {
+				return nil, fmt.Errorf("log-sink %q: %w", part, err)
+			}
+			sinks = append(sinks, sink)
+		case "otlp":
+			sinks = append(sinks, newOTLPSink(target))
+		default:
+			return nil, fmt.Errorf("unknown log-sink %q", part)
+		}
+	}
+	return sinks, nil
+}
+
+type stdoutSink struct{}
+
+func (s *stdoutSink) Log(entry jsonLogEntry) {
+	if data, err := json.Marshal(entry); err == nil {
+		fmt.Println(string(data))
+	}
+}
+
+// fileSink writes one JSON line per request to a file, rotating it once it
+// grows past maxBytes and gzip-compressing the rotated segment.
+type fileSink struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	written  int64
+	maxBytes int64
+}
+
+const defaultMaxLogBytes = 100 * 1024 * 1024 // 100MiB
+
+func newFileSink(path string) (*fileSink, error) {
+	s := &fileSink{path: path, maxBytes: defaultMaxLogBytes}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.written = info.Size()
+	return nil
+}
+
+func (s *fileSink) Log(entry jsonLogEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.written+int64(len(data)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			log.Printf("log-sink file: rotation failed: %v", err)
+		}
+	}
+	n, err := s.file.Write(data)
+	if err != nil {
+		log.Printf("log-sink file: write failed: %v", err)
+		return
+	}
+	s.written += int64(n)
+}
+
+// rotate renames the current log to a timestamped path, gzips it in the
+// background, and opens a fresh file at the original path.
+func (s *fileSink) rotate() error {
+	s.file.Close()
+	rotated := fmt.Sprintf("%s.%s", s.path, time.Now().UTC().Format("20060102T150405"))
+	if err := os.Rename(s.path, rotated); err != nil {
+		return err
+	}
+	go gzipAndRemove(rotated)
+	return s.open()
+}
+
+func gzipAndRemove(path string) {
+	in, err := os.Open(path)
+	if err != nil {
+		log.Printf("log-sink file: could not gzip %s: %v", path, err)
+		return
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		log.Printf("log-sink file: could not gzip %s: %v", path, err)
+		return
+	}
+	defer out.Close()
+
+	gw := gzip.NewWriter(out)
+	if _, err := io.Copy(gw, in); err != nil {
+		log.Printf("log-sink file: could not gzip %s: %v", path, err)
+		return
+	}
+	if err := gw.Close(); err != nil {
+		log.Printf("log-sink file: could not gzip %s: %v", path, err)
+		return
+	}
+	os.Remove(path)
+}
+
+// syslogSink (newSyslogSink) lives in logsink_syslog.go and
+// logsink_syslog_windows.go: it writes to a local unix datagram syslog
+// socket, which Windows does not provide, so the implementation is
+// build-tagged per OS.
+
+// otlpSink exports each request as an OTLP/HTTP log record.
+type otlpSink struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newOTLPSink(endpoint string) *otlpSink {
+	return &otlpSink{endpoint: endpoint, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (s *otlpSink) Log(entry jsonLogEntry) {
+	body, err := json.Marshal(otlpLogsRequest{
+		ResourceLogs: []otlpResourceLogs{{
+			ScopeLogs: []otlpScopeLogs{{
+				LogRecords: []otlpLogRecord{s.toLogRecord(entry)},
+			}},
+		}},
+	})
+	if err != nil {
+		return
+	}
+	// Export asynchronously so a slow or unreachable collector never
+	// blocks the proxy's request path.
+	go func() {
+		resp, err := s.client.Post(s.endpoint, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("log-sink otlp: export failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("log-sink otlp: collector returned %s", resp.Status)
+		}
+	}()
+}
+
+func (s *otlpSink) toLogRecord(entry jsonLogEntry) otlpLogRecord {
+	return otlpLogRecord{
+		TimeUnixNano: strconv.FormatInt(time.Now().UnixNano(), 10),
+		Body:         otlpAnyValue{StringValue: entry.URL},
+		Attributes: []otlpAttribute{
+			{Key: "http.method", Value: otlpAnyValue{StringValue: entry.Method}},
+			{Key: "url.full", Value: otlpAnyValue{StringValue: entry.URL}},
+			{Key: "http.response.status_code", Value: otlpAnyValue{IntValue: strconv.Itoa(entry.Status)}},
+			{Key: "proxy.parent", Value: otlpAnyValue{StringValue: entry.ParentProxy}},
+			{Key: "proxy.auth_method", Value: otlpAnyValue{StringValue: entry.AuthMethod}},
+			{Key: "duration_ms", Value: otlpAnyValue{DoubleValue: &entry.DurationMs}},
+			{Key: "bytes_in", Value: otlpAnyValue{IntValue: strconv.FormatInt(entry.BytesIn, 10)}},
+			{Key: "bytes_out", Value: otlpAnyValue{IntValue: strconv.FormatInt(entry.BytesOut, 10)}},
+		},
+	}
+}
+
+// The following types are a minimal subset of the OTLP/HTTP JSON logs
+// payload (opentelemetry-proto's logs.v1), just enough to carry one log
+// record per request without pulling in the full protobuf-generated SDK.
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpScopeLogs struct {
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Body         otlpAnyValue    `json:"body"`
+	Attributes   []otlpAttribute `json:"attributes"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string   `json:"stringValue,omitempty"`
+	IntValue    string   `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
@@ -0,0 +1,84 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// syslogFacilityDaemon and syslogSeverityInfo are the RFC 5424 facility
+// and severity codes alpaca's audit log entries are tagged with (daemon /
+// informational), combined into the PRI field as facility*8 + severity.
+const (
+	syslogFacilityDaemon = 3
+	syslogSeverityInfo   = 6
+)
+
+// syslogSocketPaths are the local syslog socket locations newSyslogSink
+// tries, in order. /dev/log is the Linux default; the others cover BSD,
+// macOS, and some container syslog setups.
+var syslogSocketPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogSink emits an RFC 5424 (https://www.rfc-editor.org/rfc/rfc5424)
+// formatted message per request to the local syslog daemon. It writes the
+// RFC 5424 header itself over a raw datagram socket instead of going
+// through the standard library's log/syslog package, which only produces
+// legacy RFC 3164 (BSD syslog) framing.
+type syslogSink struct {
+	conn     net.Conn
+	hostname string
+	pid      int
+}
+
+func newSyslogSink() (*syslogSink, error) {
+	var conn net.Conn
+	var err error
+	for _, path := range syslogSocketPaths {
+		conn, err = net.Dial("unixgram", path)
+		if err == nil {
+			break
+		}
+	}
+	if conn == nil {
+		return nil, fmt.Errorf("connecting to local syslog socket: %w", err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	return &syslogSink{conn: conn, hostname: hostname, pid: os.Getpid()}, nil
+}
+
+func (s *syslogSink) Log(entry jsonLogEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	pri := syslogFacilityDaemon*8 + syslogSeverityInfo
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG, with
+	// MSGID and STRUCTURED-DATA set to the RFC 5424 nil value "-".
+	msg := fmt.Sprintf("<%d>1 %s %s alpaca %d - - %s\n",
+		pri, time.Now().UTC().Format(time.RFC3339), s.hostname, s.pid, data)
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		log.Printf("log-sink syslog: write failed: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package main
+
+import "fmt"
+
+// syslogSink has no Windows implementation: the unix datagram syslog
+// socket that logsink_syslog.go writes to does not exist on Windows, and
+// alpaca has no Windows Event Log sink yet. newSyslogSink fails so that
+// -log-sink syslog produces a clear startup error instead of silently
+// dropping audit logs.
+type syslogSink struct{}
+
+func newSyslogSink() (*syslogSink, error) {
+	return nil, fmt.Errorf("log-sink syslog is not supported on windows")
+}
+
+func (s *syslogSink) Log(entry jsonLogEntry) {}
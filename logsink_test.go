@@ -0,0 +1,149 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSinkRotatesAndGzipsSegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alpaca.log")
+	s, err := newFileSink(path)
+	if err != nil {
+		t.Fatalf("newFileSink: %v", err)
+	}
+	first := jsonLogEntry{ID: 1, URL: "http://first.example.com/"}
+	firstJSON, err := json.Marshal(first)
+	if err != nil {
+		t.Fatalf("marshaling fixture: %v", err)
+	}
+	// Let the first entry fit, but force rotation before the second: big
+	// enough for one line, too small for two.
+	s.maxBytes = int64(len(firstJSON)) + 1
+
+	s.Log(first)
+	s.Log(jsonLogEntry{ID: 2, URL: "http://second.example.com/"})
+
+	// rotate() gzips the rotated segment in a background goroutine; poll
+	// briefly for it to land rather than racing it.
+	var matches []string
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		matches, err = filepath.Glob(path + ".*.gz")
+		if err != nil {
+			t.Fatalf("Glob: %v", err)
+		}
+		if len(matches) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("got %d rotated+gzipped segments, want 1: %v", len(matches), matches)
+	}
+
+	gz, err := os.Open(matches[0])
+	if err != nil {
+		t.Fatalf("opening rotated segment: %v", err)
+	}
+	defer gz.Close()
+	r, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	defer r.Close()
+
+	var entry jsonLogEntry
+	if err := json.NewDecoder(r).Decode(&entry); err != nil {
+		t.Fatalf("decoding rotated entry: %v", err)
+	}
+	if entry.ID != 1 {
+		t.Errorf("rotated entry ID = %d, want 1", entry.ID)
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading current log: %v", err)
+	}
+	var second jsonLogEntry
+	if err := json.Unmarshal(current[:len(current)-1], &second); err != nil {
+		t.Fatalf("decoding current entry: %v", err)
+	}
+	if second.ID != 2 {
+		t.Errorf("current log entry ID = %d, want 2", second.ID)
+	}
+}
+
+func TestOTLPSinkPayloadShape(t *testing.T) {
+	received := make(chan otlpLogsRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload otlpLogsRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decoding OTLP payload: %v", err)
+		}
+		received <- payload
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	s := newOTLPSink(srv.URL)
+	duration := 12.5
+	s.Log(jsonLogEntry{
+		Method:      "GET",
+		URL:         "http://example.com/",
+		Status:      200,
+		ParentProxy: "parent.example.com:3128",
+		AuthMethod:  "basic",
+		DurationMs:  duration,
+		BytesIn:     10,
+		BytesOut:    20,
+	})
+
+	select {
+	case payload := <-received:
+		if len(payload.ResourceLogs) != 1 || len(payload.ResourceLogs[0].ScopeLogs) != 1 || len(payload.ResourceLogs[0].ScopeLogs[0].LogRecords) != 1 {
+			t.Fatalf("unexpected OTLP payload shape: %+v", payload)
+		}
+		record := payload.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+		attrs := make(map[string]otlpAnyValue)
+		for _, a := range record.Attributes {
+			attrs[a.Key] = a.Value
+		}
+		if got := attrs["http.method"].StringValue; got != "GET" {
+			t.Errorf("http.method = %q, want GET", got)
+		}
+		if got := attrs["http.response.status_code"].IntValue; got != "200" {
+			t.Errorf("http.response.status_code = %q, want 200", got)
+		}
+		if got := attrs["bytes_in"].IntValue; got != "10" {
+			t.Errorf("bytes_in = %q, want 10", got)
+		}
+		if got := attrs["bytes_out"].IntValue; got != "20" {
+			t.Errorf("bytes_out = %q, want 20", got)
+		}
+		if attrs["duration_ms"].DoubleValue == nil || *attrs["duration_ms"].DoubleValue != duration {
+			t.Errorf("duration_ms = %v, want %v", attrs["duration_ms"].DoubleValue, duration)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("collector never received an export")
+	}
+}
@@ -62,10 +62,18 @@ func main() {
 	username := flag.String("u", whoAmI(), "username for proxy auth (NTLM)")
 	basicCreds := flag.String("b", "", "login:password for basic proxy auth")
 	printHash := flag.Bool("H", false, "print hashed NTLM credentials for non-interactive use")
-	kerberos := flag.Bool("k", false, "enable Kerberos/Negotiate proxy authentication (macOS only)")
-	kerberosWait := flag.Int("w", 30, "seconds to wait for a Kerberos ticket (macOS only)")
+	kerberos := flag.Bool("k", false, "enable Kerberos/Negotiate proxy authentication")
+	kerberosWait := flag.Int("w", 30, "seconds to wait for a Kerberos ticket")
 	quiet := flag.Bool("q", false, "quiet mode, suppress all log output")
 	jsonLogs := flag.Bool("json-logs", false, "emit JSON log lines on stdout")
+	logSink := flag.String("log-sink", "", "comma-separated JSON log destinations, e.g. file:/var/log/alpaca.log,otlp:https://collector:4318/v1/logs (default: stdout, when -json-logs is set)")
+	clientCert := flag.String("client-cert", "", "client certificate to present to an https:// parent proxy that requires mTLS")
+	clientKey := flag.String("client-key", "", "private key for -client-cert")
+	clientCA := flag.String("client-ca", "", "CA bundle used to verify the upstream https:// parent proxy's certificate")
+	tlsCert := flag.String("tls-cert", "", "certificate to terminate TLS on alpaca's own listener")
+	tlsKey := flag.String("tls-key", "", "private key for -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "CA bundle used to require and verify a client certificate from local applications")
+	adminPort := flag.Int("admin-port", 0, "port for the admin listener (Prometheus /metrics, /healthz, /readyz); disabled if 0")
 	version := flag.Bool("version", false, "print version number")
 	flag.Parse()
 
@@ -123,6 +131,7 @@ func main() {
 	// which method works for each proxy host.
 	var methods []proxyAuthenticator
 	if *kerberos {
+		appMetrics.kerberosTicketValid = checkKerberosTicket
 		if neg := newNegotiateAuthenticator(*kerberosWait); neg != nil {
 			log.Println("Kerberos/Negotiate authentication available")
 			methods = append(methods, neg)
@@ -136,12 +145,45 @@ func main() {
 	}
 	auth := newMultiAuthenticator(methods...)
 
+	clientTLSConfig, err := loadClientTLSConfig(*clientCert, *clientKey, *clientCA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	serverTLSConfig, err := loadServerTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	errch := make(chan error)
 
-	s, err := createServer(*port, *pacurl, auth, *jsonLogs)
+	s, proxyFinder, err := createServer(*port, *pacurl, auth, *jsonLogs, *logSink, clientTLSConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	if *adminPort != 0 {
+		ready := func() bool {
+			if !proxyFinder.Ready() {
+				return false
+			}
+			if appMetrics.kerberosTicketValid != nil && !appMetrics.kerberosTicketValid() {
+				return false
+			}
+			return true
+		}
+		admin := newAdminServer(ready)
+		adminAddress := net.JoinHostPort("localhost", strconv.Itoa(*adminPort))
+		go func() {
+			l, err := net.Listen("tcp", adminAddress)
+			if err != nil {
+				errch <- err
+				return
+			}
+			log.Printf("Admin listener (metrics, health) on %s", adminAddress)
+			errch <- admin.Serve(l)
+		}()
+	}
+
 	for _, host := range hosts {
 		address := net.JoinHostPort(host, strconv.Itoa(*port))
 		for _, network := range networks(host) {
@@ -149,10 +191,13 @@ func main() {
 				l, err := net.Listen(network, address)
 				if err != nil {
 					errch <- err
-				} else {
-					log.Printf("Listening on %s %s", network, address)
-					errch <- s.Serve(l)
+					return
 				}
+				if serverTLSConfig != nil {
+					l = tls.NewListener(l, serverTLSConfig)
+				}
+				log.Printf("Listening on %s %s", network, address)
+				errch <- s.Serve(l)
 			}(network)
 		}
 	}
@@ -161,14 +206,14 @@ func main() {
 }
 
 func createServer(
-	port int, pacurl string, auth proxyAuthenticator, jsonLogs bool,
-) (*http.Server, error) {
+	port int, pacurl string, auth proxyAuthenticator, jsonLogs bool, logSink string, clientTLS *tls.Config,
+) (*http.Server, *ProxyFinder, error) {
 	pacWrapper := NewPACWrapper(PACData{Port: port})
 	proxyFinder, err := NewProxyFinder(pacurl, pacWrapper)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	proxyHandler := NewProxyHandler(auth, getProxyFromContext, proxyFinder.blockProxy)
+	proxyHandler := NewProxyHandler(auth, getProxyFromContext, proxyFinder.blockProxy, clientTLS)
 	mux := http.NewServeMux()
 	pacWrapper.SetupHandlers(mux)
 
@@ -176,7 +221,11 @@ func createServer(
 	var handler http.Handler = mux
 	handler = proxyHandler.WrapHandler(handler)
 	if jsonLogs {
-		handler = RequestLoggerJSON(handler)
+		sinks, err := newLogSinks(logSink)
+		if err != nil {
+			return nil, nil, err
+		}
+		handler = RequestLoggerJSON(handler, sinks)
 	} else {
 		handler = RequestLogger(handler)
 	}
@@ -188,7 +237,7 @@ func createServer(
 		// TODO: Implement HTTP/2 support. In the meantime, set TLSNextProto to a non-nil
 		// value to disable HTTP/2.
 		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
-	}, nil
+	}, proxyFinder, nil
 }
 
 func networks(hostname string) []string {
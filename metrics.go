@@ -0,0 +1,189 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metrics holds the counters and histograms alpaca exposes in Prometheus
+// text format on the admin listener's /metrics endpoint. It has no
+// dependency on a Prometheus client library: the values tracked here are
+// few enough that hand-rolling the exposition format (as logsink.go does
+// for its minimal OTLP payload) is simpler than taking on the dependency.
+type metrics struct {
+	requestsTotal     *counterVec
+	requestDuration   *histogram
+	pacEvaluations    int64 // atomic
+	pacEvalDuration   *histogram
+	proxyBlockedTotal int64 // atomic
+
+	// authCacheSize and kerberosTicketValid are sampled at scrape time
+	// rather than pushed, since they reflect current state rather than
+	// an event count.
+	authCacheSize       func() int
+	kerberosTicketValid func() bool
+}
+
+var appMetrics = &metrics{
+	requestsTotal:   newCounterVec("method", "status", "parent_proxy", "auth_method"),
+	requestDuration: newHistogram(.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10),
+	pacEvalDuration: newHistogram(.001, .002, .005, .01, .025, .05, .1, .25, .5),
+}
+
+// recordRequest is called once per proxied request, from both
+// RequestLogger and RequestLoggerJSON.
+func (m *metrics) recordRequest(method string, status int, parentProxy, authMethod string, durationSeconds float64) {
+	m.requestsTotal.inc(method, fmt.Sprintf("%d", status), parentProxy, authMethod)
+	m.requestDuration.observe(durationSeconds)
+}
+
+// recordPACEvaluation is called once per PAC script evaluation, from
+// ProxyFinder.
+func (m *metrics) recordPACEvaluation(durationSeconds float64) {
+	atomic.AddInt64(&m.pacEvaluations, 1)
+	m.pacEvalDuration.observe(durationSeconds)
+}
+
+// recordProxyBlocked is called whenever ProxyFinder refuses to proxy a
+// request.
+func (m *metrics) recordProxyBlocked() {
+	atomic.AddInt64(&m.proxyBlockedTotal, 1)
+}
+
+// WritePrometheus writes the current metrics in Prometheus text exposition format.
+func (m *metrics) WritePrometheus(w io.Writer) {
+	fmt.Fprintln(w, "# HELP alpaca_requests_total Total proxied requests.")
+	fmt.Fprintln(w, "# TYPE alpaca_requests_total counter")
+	m.requestsTotal.writeTo(w, "alpaca_requests_total")
+
+	fmt.Fprintln(w, "# HELP alpaca_request_duration_seconds Time to fully serve a proxied request.")
+	fmt.Fprintln(w, "# TYPE alpaca_request_duration_seconds histogram")
+	m.requestDuration.writeTo(w, "alpaca_request_duration_seconds")
+
+	fmt.Fprintln(w, "# HELP alpaca_pac_evaluations_total Total PAC script evaluations.")
+	fmt.Fprintln(w, "# TYPE alpaca_pac_evaluations_total counter")
+	fmt.Fprintf(w, "alpaca_pac_evaluations_total %d\n", atomic.LoadInt64(&m.pacEvaluations))
+
+	fmt.Fprintln(w, "# HELP alpaca_pac_eval_duration_seconds Time to evaluate the PAC script for one request.")
+	fmt.Fprintln(w, "# TYPE alpaca_pac_eval_duration_seconds histogram")
+	m.pacEvalDuration.writeTo(w, "alpaca_pac_eval_duration_seconds")
+
+	fmt.Fprintln(w, "# HELP alpaca_proxy_blocked_total Requests refused by proxy block rules.")
+	fmt.Fprintln(w, "# TYPE alpaca_proxy_blocked_total counter")
+	fmt.Fprintf(w, "alpaca_proxy_blocked_total %d\n", atomic.LoadInt64(&m.proxyBlockedTotal))
+
+	if m.authCacheSize != nil {
+		fmt.Fprintln(w, "# HELP alpaca_auth_cache_size Entries in the per-proxy auth method cache.")
+		fmt.Fprintln(w, "# TYPE alpaca_auth_cache_size gauge")
+		fmt.Fprintf(w, "alpaca_auth_cache_size %d\n", m.authCacheSize())
+	}
+
+	if m.kerberosTicketValid != nil {
+		fmt.Fprintln(w, "# HELP alpaca_kerberos_ticket_valid Whether a usable Kerberos ticket is currently present.")
+		fmt.Fprintln(w, "# TYPE alpaca_kerberos_ticket_valid gauge")
+		fmt.Fprintf(w, "alpaca_kerberos_ticket_valid %s\n", boolToGauge(m.kerberosTicketValid()))
+	}
+}
+
+func boolToGauge(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// counterVec is a counter with a fixed, ordered set of label names.
+type counterVec struct {
+	labelNames []string
+	mu         sync.Mutex
+	counts     map[string]int64
+}
+
+func newCounterVec(labelNames ...string) *counterVec {
+	return &counterVec{labelNames: labelNames, counts: make(map[string]int64)}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	key := strings.Join(labelValues, "\x1f")
+	c.mu.Lock()
+	c.counts[key]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) writeTo(w io.Writer, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, count := range c.counts {
+		values := strings.Split(key, "\x1f")
+		fmt.Fprintf(w, "%s%s %d\n", name, formatLabels(c.labelNames, values), count)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", name, values[i])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// histogram is a fixed-bucket Prometheus-style histogram.
+type histogram struct {
+	buckets []float64
+	mu      sync.Mutex
+	counts  []int64 // counts[i] = observations <= buckets[i]
+	sum     float64
+	count   int64
+}
+
+func newHistogram(buckets ...float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]int64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) writeTo(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, fmt.Sprintf("%g", bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
@@ -0,0 +1,132 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestWritePrometheusIsValidExpositionFormat checks that every line
+// WritePrometheus emits is either a well-formed "# HELP"/"# TYPE" comment or
+// a "name{labels} value" sample, as the Prometheus text exposition format
+// (https://prometheus.io/docs/instrumenting/exposition_formats/) requires.
+func TestWritePrometheusIsValidExpositionFormat(t *testing.T) {
+	m := &metrics{
+		requestsTotal:       newCounterVec("method", "status", "parent_proxy", "auth_method"),
+		requestDuration:     newHistogram(.005, .01, .05),
+		pacEvalDuration:     newHistogram(.001, .01),
+		authCacheSize:       func() int { return 3 },
+		kerberosTicketValid: func() bool { return true },
+	}
+	m.recordRequest("GET", 200, "parent.example.com:3128", "basic", 0.02)
+	m.recordPACEvaluation(0.002)
+	m.recordProxyBlocked()
+
+	var buf bytes.Buffer
+	m.WritePrometheus(&buf)
+	out := buf.String()
+
+	wantMetrics := []string{
+		"alpaca_requests_total",
+		"alpaca_request_duration_seconds",
+		"alpaca_pac_evaluations_total",
+		"alpaca_pac_eval_duration_seconds",
+		"alpaca_proxy_blocked_total",
+		"alpaca_auth_cache_size",
+		"alpaca_kerberos_ticket_valid",
+	}
+	for _, name := range wantMetrics {
+		if !strings.Contains(out, "# TYPE "+name+" ") {
+			t.Errorf("output missing TYPE line for %s:\n%s", name, out)
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			if !strings.HasPrefix(line, "# HELP ") && !strings.HasPrefix(line, "# TYPE ") {
+				t.Errorf("malformed comment line: %q", line)
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			t.Errorf("sample line %q does not split into exactly name and value", line)
+			continue
+		}
+		if strings.Contains(fields[0], " ") {
+			t.Errorf("sample name %q contains whitespace", fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("scanning output: %v", err)
+	}
+
+	if !strings.Contains(out, `alpaca_requests_total{method="GET",status="200",parent_proxy="parent.example.com:3128",auth_method="basic"} 1`) {
+		t.Errorf("missing expected requests_total sample:\n%s", out)
+	}
+	if !strings.Contains(out, "alpaca_kerberos_ticket_valid 1") {
+		t.Errorf("missing expected kerberos_ticket_valid sample:\n%s", out)
+	}
+}
+
+func TestHistogramBucketCounts(t *testing.T) {
+	h := newHistogram(0.1, 0.5, 1)
+	for _, v := range []float64{0.05, 0.3, 0.7, 2} {
+		h.observe(v)
+	}
+
+	var buf bytes.Buffer
+	h.writeTo(&buf, "test_duration_seconds")
+	out := buf.String()
+
+	wantLines := []string{
+		`test_duration_seconds_bucket{le="0.1"} 1`,
+		`test_duration_seconds_bucket{le="0.5"} 2`,
+		`test_duration_seconds_bucket{le="1"} 3`,
+		`test_duration_seconds_bucket{le="+Inf"} 4`,
+		"test_duration_seconds_sum 3.05",
+		"test_duration_seconds_count 4",
+	}
+	for _, want := range wantLines {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestWritePrometheusOmitsUnsetGauges(t *testing.T) {
+	m := &metrics{
+		requestsTotal:   newCounterVec("method", "status", "parent_proxy", "auth_method"),
+		requestDuration: newHistogram(.1),
+		pacEvalDuration: newHistogram(.1),
+	}
+	var buf bytes.Buffer
+	m.WritePrometheus(&buf)
+	out := buf.String()
+	if strings.Contains(out, "alpaca_auth_cache_size") {
+		t.Error("alpaca_auth_cache_size should be omitted when authCacheSize is nil")
+	}
+	if strings.Contains(out, "alpaca_kerberos_ticket_valid") {
+		t.Error("alpaca_kerberos_ticket_valid should be omitted when kerberosTicketValid is nil")
+	}
+}
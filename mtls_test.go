@@ -0,0 +1,169 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert generates a throwaway self-signed certificate and key
+// pair under dir, returning their PEM file paths.
+func writeSelfSignedCert(t *testing.T, dir, name string) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(0, 0).Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshaling key: %v", err)
+	}
+
+	certPath = filepath.Join(dir, name+"-cert.pem")
+	keyPath = filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDER)
+	return certPath, keyPath
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating %s: %v", path, err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		t.Fatalf("encoding %s: %v", path, err)
+	}
+}
+
+func TestLoadClientTLSConfigEmptyIsNoop(t *testing.T) {
+	cfg, err := loadClientTLSConfig("", "", "")
+	if err != nil {
+		t.Fatalf("loadClientTLSConfig(\"\", \"\", \"\") returned error: %v", err)
+	}
+	if cfg != nil {
+		t.Errorf("loadClientTLSConfig(\"\", \"\", \"\") = %+v, want nil", cfg)
+	}
+}
+
+func TestLoadClientTLSConfigCertLoadFailure(t *testing.T) {
+	dir := t.TempDir()
+	_, err := loadClientTLSConfig(filepath.Join(dir, "missing-cert.pem"), filepath.Join(dir, "missing-key.pem"), "")
+	if err == nil {
+		t.Error("loadClientTLSConfig with a missing cert/key pair returned nil error, want one")
+	}
+}
+
+func TestLoadClientTLSConfigCAPoolFailure(t *testing.T) {
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caPath, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("writing fixture: %v", err)
+	}
+	_, err := loadClientTLSConfig("", "", caPath)
+	if err == nil {
+		t.Error("loadClientTLSConfig with an empty/invalid CA pool returned nil error, want one")
+	}
+}
+
+func TestLoadClientTLSConfigLoadsCertAndCA(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeSelfSignedCert(t, dir, "client")
+	caPath, _ := writeSelfSignedCert(t, dir, "ca")
+
+	cfg, err := loadClientTLSConfig(certPath, keyPath, caPath)
+	if err != nil {
+		t.Fatalf("loadClientTLSConfig: %v", err)
+	}
+	if len(cfg.Certificates) != 1 {
+		t.Errorf("len(Certificates) = %d, want 1", len(cfg.Certificates))
+	}
+	if cfg.RootCAs == nil {
+		t.Error("RootCAs = nil, want the loaded CA pool")
+	}
+}
+
+func TestLoadServerTLSConfigEmptyIsNoop(t *testing.T) {
+	cfg, err := loadServerTLSConfig("", "", "")
+	if err != nil {
+		t.Fatalf("loadServerTLSConfig(\"\", \"\", \"\") returned error: %v", err)
+	}
+	if cfg != nil {
+		t.Errorf("loadServerTLSConfig(\"\", \"\", \"\") = %+v, want nil", cfg)
+	}
+}
+
+func TestLoadServerTLSConfigCertLoadFailure(t *testing.T) {
+	dir := t.TempDir()
+	_, err := loadServerTLSConfig(filepath.Join(dir, "missing-cert.pem"), filepath.Join(dir, "missing-key.pem"), "")
+	if err == nil {
+		t.Error("loadServerTLSConfig with a missing cert/key pair returned nil error, want one")
+	}
+}
+
+func TestLoadServerTLSConfigRequiresClientCertWhenCAGiven(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeSelfSignedCert(t, dir, "server")
+	caPath, _ := writeSelfSignedCert(t, dir, "clientca")
+
+	cfg, err := loadServerTLSConfig(certPath, keyPath, caPath)
+	if err != nil {
+		t.Fatalf("loadServerTLSConfig: %v", err)
+	}
+	if cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("ClientAuth = %v, want RequireAndVerifyClientCert", cfg.ClientAuth)
+	}
+	if cfg.ClientCAs == nil {
+		t.Error("ClientCAs = nil, want the loaded CA pool")
+	}
+}
+
+func TestLoadServerTLSConfigCAPoolFailure(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeSelfSignedCert(t, dir, "server")
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caPath, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("writing fixture: %v", err)
+	}
+	_, err := loadServerTLSConfig(certPath, keyPath, caPath)
+	if err == nil {
+		t.Error("loadServerTLSConfig with an empty/invalid CA pool returned nil error, want one")
+	}
+}
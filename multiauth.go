@@ -23,11 +23,14 @@ import (
 )
 
 // multiAuthenticator tries multiple authentication methods in order and caches
-// which method works for each proxy host to avoid redundant retries.
+// which method works for each proxy host to avoid redundant retries. The
+// cache is also persisted to disk (see authcache.go) so that it survives
+// restarts.
 type multiAuthenticator struct {
 	methods []proxyAuthenticator
 	cache   map[string]proxyAuthenticator
 	mu      sync.RWMutex
+	disk    *authCache
 }
 
 // newMultiAuthenticator builds a proxyAuthenticator from the given methods,
@@ -47,9 +50,31 @@ func newMultiAuthenticator(methods ...proxyAuthenticator) proxyAuthenticator {
 	case 1:
 		return filtered[0]
 	default:
-		return &multiAuthenticator{
+		m := &multiAuthenticator{
 			methods: filtered,
 			cache:   make(map[string]proxyAuthenticator),
+			disk:    loadAuthCache(),
+		}
+		m.hydrateFromDisk()
+		appMetrics.authCacheSize = m.cacheSize
+		return m
+	}
+}
+
+// hydrateFromDisk seeds the in-memory cache from any non-expired entries
+// found in the on-disk auth cache, matching each entry's method name back
+// to one of m.methods.
+func (m *multiAuthenticator) hydrateFromDisk() {
+	if m.disk == nil {
+		return
+	}
+	for host, name := range m.disk.entries() {
+		for _, method := range m.methods {
+			if authMethodName(method) == name {
+				m.cache[host] = method
+				log.Printf("Restored cached auth method %q for proxy %s from disk", name, host)
+				break
+			}
 		}
 	}
 }
@@ -66,7 +91,18 @@ func (m *multiAuthenticator) do(req *http.Request, rt http.RoundTripper) (*http.
 		cached, ok := m.cache[proxyHost]
 		m.mu.RUnlock()
 		if ok {
-			return cached.do(req, rt)
+			resp, err := cached.do(req, rt)
+			if err != nil {
+				return nil, err
+			}
+			if resp.StatusCode != http.StatusProxyAuthRequired {
+				recordAuthMethod(req, cached)
+				return resp, nil
+			}
+			// The proxy has stopped accepting this method (e.g. the
+			// account's auth policy changed); drop it and re-probe below.
+			resp.Body.Close()
+			m.invalidate(proxyHost)
 		}
 	}
 
@@ -83,7 +119,13 @@ func (m *multiAuthenticator) do(req *http.Request, rt http.RoundTripper) (*http.
 				m.cache[proxyHost] = method
 				m.mu.Unlock()
 				log.Printf("Cached auth method for proxy %s", proxyHost)
+				if m.disk != nil {
+					if err := m.disk.put(proxyHost, authMethodName(method)); err != nil {
+						log.Printf("Could not persist auth cache: %v", err)
+					}
+				}
 			}
+			recordAuthMethod(req, method)
 			return resp, nil
 		}
 		// 407 — this method was rejected, try the next one.
@@ -97,3 +139,23 @@ func (m *multiAuthenticator) do(req *http.Request, rt http.RoundTripper) (*http.
 
 	return nil, fmt.Errorf("no authentication methods configured")
 }
+
+// cacheSize returns the number of proxies with a currently cached auth
+// method, for the alpaca_auth_cache_size metric.
+func (m *multiAuthenticator) cacheSize() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.cache)
+}
+
+// invalidate removes proxyHost from both the in-memory and on-disk caches.
+func (m *multiAuthenticator) invalidate(proxyHost string) {
+	m.mu.Lock()
+	delete(m.cache, proxyHost)
+	m.mu.Unlock()
+	if m.disk != nil {
+		if err := m.disk.delete(proxyHost); err != nil {
+			log.Printf("Could not update auth cache: %v", err)
+		}
+	}
+}
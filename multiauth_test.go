@@ -0,0 +1,169 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestHydrateFromDiskMatchesByMethodName(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", dir)
+
+	disk := loadAuthCache()
+	negotiateMethod := &negotiateAuthenticator{}
+	if err := disk.put("known.example.com", authMethodName(negotiateMethod)); err != nil {
+		t.Fatalf("seeding disk cache: %v", err)
+	}
+	if err := disk.put("unknown.example.com", "some-method-no-longer-configured"); err != nil {
+		t.Fatalf("seeding disk cache: %v", err)
+	}
+
+	m := &multiAuthenticator{
+		methods: []proxyAuthenticator{negotiateMethod},
+		cache:   make(map[string]proxyAuthenticator),
+		disk:    disk,
+	}
+	m.hydrateFromDisk()
+
+	if got := m.cache["known.example.com"]; got != negotiateMethod {
+		t.Errorf("cache[known.example.com] = %v, want %v", got, negotiateMethod)
+	}
+	if _, ok := m.cache["unknown.example.com"]; ok {
+		t.Error("unknown.example.com matched no configured method and should not have been hydrated")
+	}
+	if len(m.cache) != 1 {
+		t.Errorf("len(cache) = %d, want 1", len(m.cache))
+	}
+}
+
+func TestHydrateFromDiskNilDisk(t *testing.T) {
+	m := &multiAuthenticator{
+		methods: []proxyAuthenticator{&negotiateAuthenticator{}},
+		cache:   make(map[string]proxyAuthenticator),
+		disk:    nil,
+	}
+	m.hydrateFromDisk() // must not panic when there's no on-disk cache
+
+	if len(m.cache) != 0 {
+		t.Errorf("len(cache) = %d, want 0", len(m.cache))
+	}
+}
+
+// fakeAuthenticator returns the next response from responses on each call
+// to do, popping it off the front; it panics if called more than len(responses) times.
+type fakeAuthenticator struct {
+	name      string
+	responses []int
+	calls     int
+}
+
+func (f *fakeAuthenticator) do(req *http.Request, rt http.RoundTripper) (*http.Response, error) {
+	if f.calls >= len(f.responses) {
+		panic(f.name + ": called more times than it has responses queued")
+	}
+	status := f.responses[f.calls]
+	f.calls++
+	return &http.Response{StatusCode: status, Body: io.NopCloser(nil)}, nil
+}
+
+func requestForProxy(host string) *http.Request {
+	ctx := context.WithValue(context.Background(), contextKeyProxy, &url.URL{Host: host})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com/", nil)
+	return req
+}
+
+func TestMultiAuthenticatorCachesFirstWorkingMethod(t *testing.T) {
+	m1 := &fakeAuthenticator{name: "m1", responses: []int{http.StatusOK}}
+	m2 := &fakeAuthenticator{name: "m2", responses: []int{http.StatusOK}}
+	m := &multiAuthenticator{
+		methods: []proxyAuthenticator{m1, m2},
+		cache:   make(map[string]proxyAuthenticator),
+	}
+
+	resp, err := m.do(requestForProxy("proxy.example.com"), nil)
+	if err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("StatusCode = %d, want 200", resp.StatusCode)
+	}
+	if got := m.cache["proxy.example.com"]; got != m1 {
+		t.Errorf("cache[proxy.example.com] = %v, want m1", got)
+	}
+	if m2.calls != 0 {
+		t.Errorf("m2.calls = %d, want 0 (m1 should have won first)", m2.calls)
+	}
+}
+
+func TestMultiAuthenticatorReprobesAndRecachesAfterLate407(t *testing.T) {
+	// m1 works once (gets cached), then starts returning 407 on a later
+	// request — simulating a proxy whose auth policy changed underneath a
+	// cached method. do() invalidates the cache entry and falls through to
+	// re-probe every method in order (including m1 again), so m1 is
+	// rejected twice before m2 takes over and gets cached instead.
+	m1 := &fakeAuthenticator{name: "m1", responses: []int{http.StatusOK, http.StatusProxyAuthRequired, http.StatusProxyAuthRequired}}
+	m2 := &fakeAuthenticator{name: "m2", responses: []int{http.StatusOK}}
+	m := &multiAuthenticator{
+		methods: []proxyAuthenticator{m1, m2},
+		cache:   make(map[string]proxyAuthenticator),
+	}
+	const host = "proxy.example.com"
+
+	if _, err := m.do(requestForProxy(host), nil); err != nil {
+		t.Fatalf("first do: %v", err)
+	}
+	if got := m.cache[host]; got != m1 {
+		t.Fatalf("cache[%s] = %v, want m1 after first request", host, got)
+	}
+
+	resp, err := m.do(requestForProxy(host), nil)
+	if err != nil {
+		t.Fatalf("second do: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("StatusCode = %d, want 200 after re-probing", resp.StatusCode)
+	}
+	if got := m.cache[host]; got != m2 {
+		t.Errorf("cache[%s] = %v, want m2 after m1 was invalidated", host, got)
+	}
+	if m1.calls != 3 {
+		t.Errorf("m1.calls = %d, want 3 (initial probe, rejected cached hit, and re-probe)", m1.calls)
+	}
+}
+
+func TestMultiAuthenticatorReturnsLast407WhenAllMethodsFail(t *testing.T) {
+	m1 := &fakeAuthenticator{name: "m1", responses: []int{http.StatusProxyAuthRequired}}
+	m2 := &fakeAuthenticator{name: "m2", responses: []int{http.StatusProxyAuthRequired}}
+	m := &multiAuthenticator{
+		methods: []proxyAuthenticator{m1, m2},
+		cache:   make(map[string]proxyAuthenticator),
+	}
+
+	resp, err := m.do(requestForProxy("proxy.example.com"), nil)
+	if err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("StatusCode = %d, want 407", resp.StatusCode)
+	}
+	if _, ok := m.cache["proxy.example.com"]; ok {
+		t.Error("cache should stay empty when every method is rejected")
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+type negotiateAuthenticator struct{}
+
+// newNegotiateAuthenticator checks for a Kerberos ticket in the current
+// user's credential cache and returns a negotiateAuthenticator if one is
+// available. If waitSeconds > 0 and no ticket is found immediately, it
+// polls every 2 seconds up to the given timeout. Returns nil if no ticket is
+// available.
+func newNegotiateAuthenticator(waitSeconds int) proxyAuthenticator {
+	if checkKerberosTicket() {
+		log.Println("Kerberos ticket found")
+		return &negotiateAuthenticator{}
+	}
+	if waitSeconds <= 0 {
+		return nil
+	}
+	log.Printf("No Kerberos ticket found, waiting up to %d seconds...", waitSeconds)
+	if waitForKerberosTicket(waitSeconds) {
+		log.Println("Kerberos ticket found")
+		return &negotiateAuthenticator{}
+	}
+	log.Println("No Kerberos ticket found after waiting")
+	return nil
+}
+
+// ccachePath returns the location of the current user's Kerberos
+// credential cache, honoring KRB5CCNAME and falling back to the MIT
+// krb5 default of /tmp/krb5cc_<uid>.
+func ccachePath() string {
+	if path := os.Getenv("KRB5CCNAME"); path != "" {
+		path, _ = trimCCachePrefix(path)
+		return path
+	}
+	return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+}
+
+// trimCCachePrefix strips the optional "FILE:" prefix that KRB5CCNAME may
+// carry.
+func trimCCachePrefix(path string) (string, bool) {
+	const prefix = "FILE:"
+	if len(path) > len(prefix) && path[:len(prefix)] == prefix {
+		return path[len(prefix):], true
+	}
+	return path, false
+}
+
+// checkKerberosTicket returns true if the credential cache holds a
+// non-expired ticket-granting ticket, found by looking for a "krbtgt"
+// server principal among the cache's credential entries.
+func checkKerberosTicket() bool {
+	cache, err := credentials.LoadCCache(ccachePath())
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	for _, cred := range cache.Credentials {
+		if len(cred.Server.PrincipalName.NameString) > 0 &&
+			cred.Server.PrincipalName.NameString[0] == "krbtgt" &&
+			now.Before(cred.EndTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForKerberosTicket polls for a Kerberos ticket every 2 seconds up to
+// timeout.
+func waitForKerberosTicket(timeoutSeconds int) bool {
+	deadline := time.Now().Add(time.Duration(timeoutSeconds) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+		if checkKerberosTicket() {
+			return true
+		}
+	}
+	return false
+}
+
+// generateSPNEGOToken creates a SPNEGO AP-REQ token for the given proxy
+// host using the current user's credential cache.
+// spnego.SPNEGOClient(cl, spn).InitSecContext() builds the client's
+// security context and returns the resulting SPNEGO token ready to marshal
+// into the Proxy-Authorization header.
+func generateSPNEGOToken(proxyHost string) ([]byte, error) {
+	cache, err := credentials.LoadCCache(ccachePath())
+	if err != nil {
+		return nil, fmt.Errorf("loading krb5 credential cache: %w", err)
+	}
+	cl, err := client.NewFromCCache(cache, config.New())
+	if err != nil {
+		return nil, fmt.Errorf("building krb5 client: %w", err)
+	}
+
+	spn := "HTTP/" + proxyHost
+	token, err := spnego.SPNEGOClient(cl, spn).InitSecContext()
+	if err != nil {
+		return nil, fmt.Errorf("generating SPNEGO token: %w", err)
+	}
+	return token.Marshal()
+}
+
+// do performs Negotiate/SPNEGO proxy authentication. It generates a SPNEGO
+// token for the upstream proxy and sends the request with a
+// Proxy-Authorization: Negotiate header.
+func (n *negotiateAuthenticator) do(req *http.Request, rt http.RoundTripper) (*http.Response, error) {
+	proxyHost := ""
+	if value := req.Context().Value(contextKeyProxy); value != nil {
+		proxy := value.(*url.URL)
+		proxyHost = proxy.Hostname()
+	}
+	if proxyHost == "" {
+		return nil, fmt.Errorf("cannot determine proxy host for Negotiate auth")
+	}
+
+	token, err := generateSPNEGOToken(proxyHost)
+	if err != nil {
+		log.Printf("Error generating SPNEGO token for %s: %v", proxyHost, err)
+		return nil, err
+	}
+
+	req.Header.Set("Proxy-Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+	return rt.RoundTrip(req)
+}
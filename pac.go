@@ -0,0 +1,304 @@
+// Copyright 2019, 2021, 2022, 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// contextKey is the type used for values stored in request contexts by
+// alpaca. A dedicated type avoids collisions with context keys from other
+// packages.
+type contextKey string
+
+const contextKeyProxy contextKey = "proxy"
+
+// PACData holds the values substituted into the bundled default PAC script.
+type PACData struct {
+	Port int
+}
+
+// PACWrapper serves a proxy auto-config file at /proxy.pac and /wpad.dat.
+// The served script is always the fixed one in servePAC, which sends every
+// request DIRECT; it is not built from data, and the -C script is not
+// served. Evaluating the PAC script against request URLs is ProxyFinder's
+// job, not PACWrapper's.
+type PACWrapper struct {
+	data PACData
+}
+
+// NewPACWrapper returns a PACWrapper holding the given PACData. servePAC
+// does not read it.
+func NewPACWrapper(data PACData) *PACWrapper {
+	return &PACWrapper{data: data}
+}
+
+// SetupHandlers registers the routes used to serve the PAC file itself.
+func (p *PACWrapper) SetupHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/proxy.pac", p.servePAC)
+	mux.HandleFunc("/wpad.dat", p.servePAC)
+}
+
+func (p *PACWrapper) servePAC(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	fmt.Fprintf(w, "function FindProxyForURL(url, host) {\n  return \"DIRECT\";\n}\n")
+}
+
+// defaultPACScript is the FindProxyForURL implementation used when no -C
+// pacurl is given: every request goes DIRECT.
+const defaultPACScript = `function FindProxyForURL(url, host) { return "DIRECT"; }`
+
+// pacUtilsScript implements the standard helper functions a PAC script's
+// FindProxyForURL may call (isInNet, dnsDomainIs, shExpMatch, ...), as
+// specified by Netscape's original proxy-auto-config documentation. It is
+// evaluated into the VM ahead of the configured script so conditional PAC
+// files that use them work without alpaca special-casing each one.
+// dnsResolve and myIpAddress are implemented natively (see registerPACHostFuncs)
+// since otto has no network access of its own.
+const pacUtilsScript = `
+function isPlainHostName(host) {
+	return host.indexOf('.') === -1;
+}
+function dnsDomainIs(host, domain) {
+	return host.length >= domain.length && host.substring(host.length - domain.length) === domain;
+}
+function localHostOrDomainIs(host, hostdom) {
+	return host === hostdom || dnsDomainIs(host, hostdom.substring(hostdom.indexOf('.')));
+}
+function isResolvable(host) {
+	return dnsResolve(host) !== null;
+}
+function isInNet(host, pattern, mask) {
+	var ip = isPlainHostName(host) ? host : dnsResolve(host);
+	if (ip === null) {
+		return false;
+	}
+	var ipParts = convertAddr(ip), patternParts = convertAddr(pattern), maskParts = convertAddr(mask);
+	return (ipParts & maskParts) === (patternParts & maskParts);
+}
+function convertAddr(ipchars) {
+	var bytes = ipchars.split('.');
+	return ((bytes[0] & 0xff) << 24) | ((bytes[1] & 0xff) << 16) | ((bytes[2] & 0xff) << 8) | (bytes[3] & 0xff);
+}
+function dnsDomainLevels(host) {
+	return host.split('.').length - 1;
+}
+function shExpMatch(str, shexp) {
+	var re = '^' + shexp.replace(/[.+^${}()|[\]\\]/g, '\\$&').replace(/\*/g, '.*').replace(/\?/g, '.') + '$';
+	return new RegExp(re).test(str);
+}
+function weekdayRange() { return false; }
+function dateRange() { return false; }
+function timeRange() { return false; }
+`
+
+// ProxyFinder evaluates the PAC script for each incoming request and
+// records the chosen upstream proxy (if any) in the request context. It
+// runs the script with otto, a pure-Go ECMAScript 5 interpreter, since a
+// real-world PAC file can call arbitrary JS helpers (isInNet, dnsDomainIs,
+// shExpMatch, ...) that no amount of string matching can cover.
+type ProxyFinder struct {
+	pacWrapper *PACWrapper
+	vm         *otto.Otto
+	mu         sync.Mutex // otto.Otto is not safe for concurrent use
+	ready      int32      // atomic bool: the PAC script was fetched and loaded successfully
+}
+
+// NewProxyFinder returns a ProxyFinder that evaluates the PAC file at
+// pacurl (or the bundled default, if pacurl is empty) for every request.
+// pacurl may be an http(s):// URL or a local file path. The script is
+// fetched and parsed once, here, so that a malformed or unreachable PAC
+// file fails fast at startup rather than on the first request.
+func NewProxyFinder(pacurl string, pacWrapper *PACWrapper) (*ProxyFinder, error) {
+	script, err := fetchPACScript(pacurl)
+	if err != nil {
+		return nil, fmt.Errorf("loading PAC file: %w", err)
+	}
+	vm := otto.New()
+	registerPACHostFuncs(vm)
+	if _, err := vm.Run(pacUtilsScript); err != nil {
+		return nil, fmt.Errorf("loading PAC helper functions: %w", err)
+	}
+	if _, err := vm.Run(script); err != nil {
+		return nil, fmt.Errorf("parsing PAC file: %w", err)
+	}
+	f := &ProxyFinder{pacWrapper: pacWrapper, vm: vm}
+	atomic.StoreInt32(&f.ready, 1)
+	return f, nil
+}
+
+// registerPACHostFuncs binds the PAC helper functions that need access to
+// the host's resolver and network interfaces, since otto has no built-in
+// way to do either.
+func registerPACHostFuncs(vm *otto.Otto) {
+	vm.Set("dnsResolve", func(call otto.FunctionCall) otto.Value {
+		host := call.Argument(0).String()
+		addrs, err := net.LookupHost(host)
+		if err != nil || len(addrs) == 0 {
+			v, _ := otto.ToValue(nil)
+			return v
+		}
+		v, _ := otto.ToValue(addrs[0])
+		return v
+	})
+	vm.Set("myIpAddress", func(call otto.FunctionCall) otto.Value {
+		ip := "127.0.0.1"
+		if conn, err := net.Dial("udp", "8.8.8.8:80"); err == nil {
+			ip = conn.LocalAddr().(*net.UDPAddr).IP.String()
+			conn.Close()
+		}
+		v, _ := otto.ToValue(ip)
+		return v
+	})
+}
+
+// fetchPACScript returns the PAC script source for pacurl: the bundled
+// default if pacurl is empty, the body of an http(s):// URL, or the
+// contents of a local file.
+func fetchPACScript(pacurl string) (string, error) {
+	if pacurl == "" {
+		return defaultPACScript, nil
+	}
+	if strings.HasPrefix(pacurl, "http://") || strings.HasPrefix(pacurl, "https://") {
+		resp, err := http.Get(pacurl)
+		if err != nil {
+			return "", fmt.Errorf("fetching %s: %w", pacurl, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("fetching %s: unexpected status %s", pacurl, resp.Status)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", pacurl, err)
+		}
+		return string(body), nil
+	}
+	body, err := os.ReadFile(pacurl)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", pacurl, err)
+	}
+	return string(body), nil
+}
+
+// WrapHandler evaluates the PAC script for the request URL and stashes the
+// chosen parent proxy (as a *url.URL, with its Scheme set to the upstream
+// proxy type: "http", "https", or "socks5") in the request context before
+// calling next. A nil value (or a URL with no Host) means DIRECT.
+func (f *ProxyFinder) WrapHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if proxy := f.findProxy(req); proxy != nil {
+			ctx := context.WithValue(req.Context(), contextKeyProxy, proxy)
+			req = req.WithContext(ctx)
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// findProxy evaluates the PAC script and returns the first upstream proxy
+// directive that parsePACDirective accepts, parsed into a *url.URL with an
+// appropriate scheme. The proxy's reachability is not checked. It returns
+// nil for DIRECT.
+func (f *ProxyFinder) findProxy(req *http.Request) *url.URL {
+	start := time.Now()
+	result := f.findProxyForURL(req.URL)
+	appMetrics.recordPACEvaluation(time.Since(start).Seconds())
+
+	directives := strings.Split(result, ";")
+	for _, directive := range directives {
+		proxy, err := parsePACDirective(strings.TrimSpace(directive))
+		if err != nil {
+			continue
+		}
+		return proxy
+	}
+	return nil
+}
+
+// Ready reports whether the PAC script was fetched and loaded
+// successfully, for use by the admin server's /readyz probe.
+func (f *ProxyFinder) Ready() bool {
+	return atomic.LoadInt32(&f.ready) == 1
+}
+
+// findProxyForURL calls the PAC script's FindProxyForURL(url, host) and
+// returns its result (e.g. "DIRECT", "PROXY host:port; DIRECT"). A script
+// error is treated the same as DIRECT, since failing open is safer for a
+// forward proxy than dropping the request.
+func (f *ProxyFinder) findProxyForURL(u *url.URL) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, err := f.vm.Call("FindProxyForURL", nil, u.String(), u.Hostname())
+	if err != nil {
+		log.Printf("evaluating PAC script for %s: %v; falling back to DIRECT", u, err)
+		return "DIRECT"
+	}
+	return value.String()
+}
+
+// parsePACDirective parses a single clause returned by FindProxyForURL
+// (e.g. "PROXY host:port", "HTTPS host:port", "SOCKS5 host:port", "SOCKS
+// host:port", or "DIRECT") into a *url.URL whose Scheme identifies how to
+// reach that upstream: "http" for PROXY, "https" for HTTPS, and "socks5"
+// for both SOCKS5 and SOCKS (alpaca only speaks the SOCKS5 wire protocol
+// upstream). DIRECT and unrecognized directives return an error.
+func parsePACDirective(directive string) (*url.URL, error) {
+	fields := strings.Fields(directive)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("not a proxy directive: %q", directive)
+	}
+	var scheme string
+	switch strings.ToUpper(fields[0]) {
+	case "PROXY":
+		scheme = "http"
+	case "HTTPS":
+		scheme = "https"
+	case "SOCKS5", "SOCKS":
+		scheme = "socks5"
+	default:
+		return nil, fmt.Errorf("unsupported PAC directive: %q", directive)
+	}
+	return &url.URL{Scheme: scheme, Host: fields[1]}, nil
+}
+
+// blockProxy reports whether the given request should be refused outright
+// (e.g. because PAC evaluation failed and no DIRECT fallback is allowed).
+// alpaca never blocks requests today, but ProxyHandler takes the hook so
+// that behaviour can change without altering its constructor signature.
+func (f *ProxyFinder) blockProxy(req *http.Request) bool {
+	return false
+}
+
+// getProxyFromContext returns the upstream proxy URL chosen for req by
+// ProxyFinder.WrapHandler, or nil if the request should go DIRECT.
+func getProxyFromContext(req *http.Request) *url.URL {
+	if value := req.Context().Value(contextKeyProxy); value != nil {
+		return value.(*url.URL)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestParsePACDirective(t *testing.T) {
+	tests := []struct {
+		directive  string
+		wantScheme string
+		wantHost   string
+		wantErr    bool
+	}{
+		{"PROXY proxy.example.com:8080", "http", "proxy.example.com:8080", false},
+		{"HTTPS proxy.example.com:8443", "https", "proxy.example.com:8443", false},
+		{"SOCKS5 socks.example.com:1080", "socks5", "socks.example.com:1080", false},
+		{"SOCKS socks.example.com:1080", "socks5", "socks.example.com:1080", false},
+		{"proxy proxy.example.com:8080", "http", "proxy.example.com:8080", false},
+		{"DIRECT", "", "", true},
+		{"BOGUS host:1", "", "", true},
+		{"PROXY", "", "", true},
+		{"", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parsePACDirective(tt.directive)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parsePACDirective(%q) = %v, want error", tt.directive, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePACDirective(%q) returned unexpected error: %v", tt.directive, err)
+			continue
+		}
+		if got.Scheme != tt.wantScheme || got.Host != tt.wantHost {
+			t.Errorf("parsePACDirective(%q) = %s://%s, want %s://%s", tt.directive, got.Scheme, got.Host, tt.wantScheme, tt.wantHost)
+		}
+	}
+}
+
+func TestFetchPACScriptDefault(t *testing.T) {
+	script, err := fetchPACScript("")
+	if err != nil {
+		t.Fatalf("fetchPACScript(\"\") returned error: %v", err)
+	}
+	if script != defaultPACScript {
+		t.Errorf("fetchPACScript(\"\") = %q, want bundled default", script)
+	}
+}
+
+func TestFetchPACScriptLocalFile(t *testing.T) {
+	path := t.TempDir() + "/proxy.pac"
+	const contents = `function FindProxyForURL(url, host) { return "SOCKS5 gateway.example.com:1080"; }`
+	if err := writeFile(path, contents); err != nil {
+		t.Fatalf("writing fixture: %v", err)
+	}
+	script, err := fetchPACScript(path)
+	if err != nil {
+		t.Fatalf("fetchPACScript(%q) returned error: %v", path, err)
+	}
+	if script != contents {
+		t.Errorf("fetchPACScript(%q) = %q, want %q", path, script, contents)
+	}
+}
+
+func TestNewProxyFinderSingleDirective(t *testing.T) {
+	path := t.TempDir() + "/proxy.pac"
+	const contents = `function FindProxyForURL(url, host) { return "SOCKS5 gateway.example.com:1080"; }`
+	if err := writeFile(path, contents); err != nil {
+		t.Fatalf("writing fixture: %v", err)
+	}
+	f, err := NewProxyFinder(path, NewPACWrapper(PACData{}))
+	if err != nil {
+		t.Fatalf("NewProxyFinder: %v", err)
+	}
+	u, _ := url.Parse("http://anything.example.com/")
+	if got, want := f.findProxyForURL(u), "SOCKS5 gateway.example.com:1080"; got != want {
+		t.Errorf("findProxyForURL() = %q, want %q", got, want)
+	}
+	if !f.Ready() {
+		t.Error("Ready() = false, want true after successful load")
+	}
+}
+
+func TestNewProxyFinderConditionalScriptEvaluatesPerRequest(t *testing.T) {
+	path := t.TempDir() + "/proxy.pac"
+	const contents = `function FindProxyForURL(url, host) {
+		if (dnsDomainIs(host, ".internal.example.com")) { return "DIRECT"; }
+		return "SOCKS5 gateway.example.com:1080";
+	}`
+	if err := writeFile(path, contents); err != nil {
+		t.Fatalf("writing fixture: %v", err)
+	}
+	f, err := NewProxyFinder(path, NewPACWrapper(PACData{}))
+	if err != nil {
+		t.Fatalf("NewProxyFinder: %v", err)
+	}
+
+	internal, _ := url.Parse("http://host.internal.example.com/")
+	if got, want := f.findProxyForURL(internal), "DIRECT"; got != want {
+		t.Errorf("findProxyForURL(%s) = %q, want %q", internal, got, want)
+	}
+
+	external, _ := url.Parse("http://example.org/")
+	if got, want := f.findProxyForURL(external), "SOCKS5 gateway.example.com:1080"; got != want {
+		t.Errorf("findProxyForURL(%s) = %q, want %q", external, got, want)
+	}
+}
+
+func writeFile(path, contents string) error {
+	return os.WriteFile(path, []byte(contents), 0o600)
+}
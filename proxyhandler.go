@@ -0,0 +1,322 @@
+// Copyright 2019, 2021, 2022, 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"golang.org/x/net/proxy"
+)
+
+const contextKeyID contextKey = "id"
+
+// AddContextID stamps each incoming request's context with a monotonically
+// increasing ID (used to correlate log lines for the same request) and a
+// fresh *requestMetrics (used by downstream handlers to report the auth
+// method and byte counts that RequestLoggerJSON later logs).
+func AddContextID(next http.Handler) http.Handler {
+	var nextID uint64
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := atomic.AddUint64(&nextID, 1)
+		ctx := context.WithValue(req.Context(), contextKeyID, id)
+		ctx = context.WithValue(ctx, contextKeyMetrics, &requestMetrics{})
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// proxyAuthenticator performs (or skips) proxy authentication for a single
+// request/round-trip, retrying with credentials if the upstream proxy
+// challenges with a 407.
+type proxyAuthenticator interface {
+	do(req *http.Request, rt http.RoundTripper) (*http.Response, error)
+}
+
+// ProxyHandler forwards HTTP requests (and tunnels HTTPS CONNECT requests)
+// to the parent proxy chosen by getProxy, dialing directly when getProxy
+// returns nil.
+type ProxyHandler struct {
+	auth       proxyAuthenticator
+	getProxy   func(*http.Request) *url.URL
+	blockProxy func(*http.Request) bool
+	clientTLS  *tls.Config
+}
+
+// NewProxyHandler returns a ProxyHandler that authenticates upstream
+// requests with auth, resolves the parent proxy for each request with
+// getProxy, and refuses requests that blockProxy reports as disallowed.
+// clientTLS, if non-nil, is used (and, for CONNECT, presented) when
+// dialing an https:// parent proxy that requires mTLS; it may be nil.
+func NewProxyHandler(
+	auth proxyAuthenticator, getProxy func(*http.Request) *url.URL, blockProxy func(*http.Request) bool,
+	clientTLS *tls.Config,
+) *ProxyHandler {
+	return &ProxyHandler{auth: auth, getProxy: getProxy, blockProxy: blockProxy, clientTLS: clientTLS}
+}
+
+// WrapHandler intercepts proxy requests (CONNECT, and any request with an
+// absolute-form URI) and forwards or tunnels them upstream. Any other
+// request (e.g. a GET for the PAC file) is passed through to next.
+func (h *ProxyHandler) WrapHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodConnect && req.URL.Host == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if h.blockProxy(req) {
+			appMetrics.recordProxyBlocked()
+			http.Error(w, "proxying to this destination is not allowed", http.StatusForbidden)
+			return
+		}
+		if req.Method == http.MethodConnect {
+			h.serveConnect(w, req)
+			return
+		}
+		h.serveForward(w, req)
+	})
+}
+
+func (h *ProxyHandler) roundTripper(req *http.Request) (http.RoundTripper, error) {
+	parent := h.getProxy(req)
+	transport := &http.Transport{}
+	if parent == nil {
+		return transport, nil
+	}
+	switch parent.Scheme {
+	case "socks5":
+		dialer, err := proxy.SOCKS5("tcp", parent.Host, nil, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("dialing SOCKS5 parent proxy %s: %w", parent.Host, err)
+		}
+		// proxy.SOCKS5's dialer implements proxy.ContextDialer; use
+		// DialContext (not the legacy Dial hook) so a request timeout or
+		// client disconnect can still abort a hung SOCKS5 dial/handshake,
+		// same as the direct/http/https branches below.
+		transport.DialContext = dialer.(proxy.ContextDialer).DialContext
+	case "https":
+		transport.Proxy = http.ProxyURL(parent)
+		transport.TLSClientConfig = h.clientTLS
+	default:
+		transport.Proxy = http.ProxyURL(parent)
+	}
+	return transport, nil
+}
+
+func (h *ProxyHandler) serveForward(w http.ResponseWriter, req *http.Request) {
+	req.RequestURI = ""
+	var metrics *requestMetrics
+	if value := req.Context().Value(contextKeyMetrics); value != nil {
+		metrics = value.(*requestMetrics)
+	}
+	if req.Body != nil && metrics != nil {
+		req.Body = &countingReadCloser{ReadCloser: req.Body, n: &metrics.RequestBytesIn}
+	}
+	rt, err := h.roundTripper(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	resp, err := h.auth.do(req, rt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	copyHeader(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// countingReadCloser wraps an io.ReadCloser and atomically adds every byte
+// read to n, so serveForward can report how much of the request body it
+// sent upstream (RequestLoggerJSON's bytes_in, mirroring how statusWriter
+// counts response bytes).
+type countingReadCloser struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// serveConnect tunnels a CONNECT request, dialing the destination directly
+// or via the chosen parent proxy (including SOCKS5 parents, for which
+// golang.org/x/net/proxy negotiates the CONNECT-equivalent handshake).
+func (h *ProxyHandler) serveConnect(w http.ResponseWriter, req *http.Request) {
+	parent := h.getProxy(req)
+	var upstream net.Conn
+	var err error
+	if parent == nil {
+		upstream, err = net.Dial("tcp", req.URL.Host)
+	} else if parent.Scheme == "socks5" {
+		var dialer proxy.Dialer
+		dialer, err = proxy.SOCKS5("tcp", parent.Host, nil, proxy.Direct)
+		if err == nil {
+			upstream, err = dialer.Dial("tcp", req.URL.Host)
+		}
+	} else {
+		upstream, err = h.connectViaHTTPProxy(req, parent, req.URL.Host)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection does not support hijacking", http.StatusInternalServerError)
+		return
+	}
+	client, _, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Close()
+
+	fmt.Fprintf(client, "HTTP/1.1 200 Connection Established\r\n\r\n")
+
+	var metrics *requestMetrics
+	if value := req.Context().Value(contextKeyMetrics); value != nil {
+		metrics = value.(*requestMetrics)
+	}
+
+	// Wait for both directions to finish (not just whichever EOFs first):
+	// a client that has sent its request and half-closed its write side
+	// (the common request-then-read-response pattern) must still get the
+	// rest of an in-progress response, not have it truncated the instant
+	// the other direction sees EOF. Each goroutine half-closes its
+	// destination's write side as it finishes, so the still-running
+	// direction can itself drain to EOF and exit normally.
+	done := make(chan struct{}, 2)
+	go func() {
+		n, _ := io.Copy(upstream, client)
+		if metrics != nil {
+			atomic.AddInt64(&metrics.TunnelBytesIn, n)
+		}
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		n, _ := io.Copy(client, upstream)
+		if metrics != nil {
+			atomic.AddInt64(&metrics.TunnelBytesOut, n)
+		}
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// closeWrite half-closes conn's write side if it supports one (e.g.
+// *net.TCPConn), signaling EOF to the peer without tearing down the read
+// side. Connection types with no half-close (e.g. *tls.Conn on older Go
+// versions) are left alone; the deferred full Close once both tunnel
+// directions finish is what eventually cleans them up.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
+
+// connectRoundTripper implements http.RoundTripper over a single upstream
+// proxy connection, so that h.auth can drive the same authentication flow
+// (adding credentials, retrying on a 407 challenge) for a CONNECT tunnel
+// that it already drives for forwarded requests in serveForward.
+type connectRoundTripper struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func (c *connectRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, err := fmt.Fprintf(c.conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n", req.URL.Host, req.URL.Host); err != nil {
+		return nil, fmt.Errorf("writing CONNECT request: %w", err)
+	}
+	if err := req.Header.Write(c.conn); err != nil {
+		return nil, fmt.Errorf("writing CONNECT headers: %w", err)
+	}
+	if _, err := io.WriteString(c.conn, "\r\n"); err != nil {
+		return nil, fmt.Errorf("writing CONNECT request: %w", err)
+	}
+	resp, err := http.ReadResponse(c.br, req)
+	if err != nil {
+		return nil, fmt.Errorf("reading CONNECT response: %w", err)
+	}
+	return resp, nil
+}
+
+// connectViaHTTPProxy dials parent and issues a CONNECT for target,
+// authenticating through h.auth exactly as serveForward does for forwarded
+// requests — including retrying with credentials on a 407 challenge —
+// before the tunnel is considered established. It returns an error, never
+// a connection, unless the parent proxy confirms the tunnel with a 200
+// response; serveConnect must not tell the client "Connection Established"
+// on anything else.
+func (h *ProxyHandler) connectViaHTTPProxy(req *http.Request, parent *url.URL, target string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if parent.Scheme == "https" {
+		conn, err = tls.Dial("tcp", parent.Host, h.clientTLS)
+	} else {
+		conn, err = net.Dial("tcp", parent.Host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	crt := &connectRoundTripper{conn: conn, br: bufio.NewReader(conn)}
+	connReq := (&http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: target},
+		Header: make(http.Header),
+	}).WithContext(req.Context())
+
+	resp, err := h.auth.do(connReq, crt)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT via parent proxy %s: %w", parent.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("parent proxy %s refused CONNECT: %s", parent.Host, resp.Status)
+	}
+	// It's safe to discard crt.br here (rather than splice its buffered
+	// reader back in front of conn): the far end of a successful CONNECT
+	// won't speak until spoken to, so nothing past the response headers
+	// can have been buffered. net/http's own Transport makes the same
+	// assumption when establishing a CONNECT tunnel.
+	return conn, nil
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, values := range src {
+		for _, v := range values {
+			dst.Add(k, v)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2025 The Alpaca Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// passthroughAuthenticator performs no authentication at all: it hands req
+// straight to rt. It stands in for the real proxyAuthenticator
+// implementations (basic/NTLM/negotiate) so these tests can observe
+// exactly what the parent proxy sent back, unmodified.
+type passthroughAuthenticator struct{}
+
+func (passthroughAuthenticator) do(req *http.Request, rt http.RoundTripper) (*http.Response, error) {
+	return rt.RoundTrip(req)
+}
+
+// startFakeParentProxy starts a TCP listener that reads one CONNECT
+// request and replies with statusLine, then closes the connection. It
+// returns the listener's address.
+func startFakeParentProxy(t *testing.T, statusLine string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+			return
+		}
+		fmt.Fprintf(conn, "HTTP/1.1 %s\r\n\r\n", statusLine)
+	}()
+	return ln.Addr().String()
+}
+
+func newTestProxyHandler(parent *url.URL) *ProxyHandler {
+	return NewProxyHandler(
+		passthroughAuthenticator{},
+		func(*http.Request) *url.URL { return parent },
+		func(*http.Request) bool { return false },
+		nil,
+	)
+}
+
+// sendRawConnect issues a raw CONNECT request to addr and returns the
+// parsed response, bypassing net/http's client (which would transparently
+// retry or hide a non-200 CONNECT reply).
+func sendRawConnect(t *testing.T, addr, target string) *http.Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dialing %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	return resp
+}
+
+func TestServeConnectDoesNotConfirmTunnelOnParent407(t *testing.T) {
+	parentAddr := startFakeParentProxy(t, "407 Proxy Authentication Required")
+	parent := &url.URL{Scheme: "http", Host: parentAddr}
+
+	ts := httptest.NewServer(newTestProxyHandler(parent).WrapHandler(http.NotFoundHandler()))
+	defer ts.Close()
+	tsURL, _ := url.Parse(ts.URL)
+
+	resp := sendRawConnect(t, tsURL.Host, "example.com:443")
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("serveConnect told the client the tunnel was established even though the parent proxy returned 407")
+	}
+}
+
+func TestServeConnectConfirmsTunnelOnParent200(t *testing.T) {
+	parentAddr := startFakeParentProxy(t, "200 Connection Established")
+	parent := &url.URL{Scheme: "http", Host: parentAddr}
+
+	ts := httptest.NewServer(newTestProxyHandler(parent).WrapHandler(http.NotFoundHandler()))
+	defer ts.Close()
+	tsURL, _ := url.Parse(ts.URL)
+
+	resp := sendRawConnect(t, tsURL.Host, "example.com:443")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %q, want 200 Connection Established", resp.Status)
+	}
+}
+
+// startFakeDestination starts a TCP listener standing in for a tunneled
+// destination: it drains whatever the client sends (simulating the client
+// half-closing its write side once its request is out) and only then
+// writes response back, so a test can tell whether the tunnel relay
+// tore down the read-from-upstream direction early.
+func startFakeDestination(t *testing.T, response []byte) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn) // wait for the client's half-close
+		conn.Write(response)
+	}()
+	return ln.Addr().String()
+}
+
+// TestServeConnectDoesNotTruncateResponseOnClientHalfClose reproduces a
+// client that sends its request and then half-closes its write side (the
+// normal request-then-read-response pattern many HTTP clients use over a
+// CONNECT tunnel). The still-in-flight client<-upstream direction must be
+// allowed to finish, not be torn down the instant the upstream<-client
+// direction sees EOF.
+func TestServeConnectDoesNotTruncateResponseOnClientHalfClose(t *testing.T) {
+	want := bytes.Repeat([]byte("tunneled-response-data"), 10000) // a few hundred KB
+	destAddr := startFakeDestination(t, want)
+
+	ts := httptest.NewServer(newTestProxyHandler(nil).WrapHandler(http.NotFoundHandler()))
+	defer ts.Close()
+	tsURL, _ := url.Parse(ts.URL)
+
+	conn, err := net.Dial("tcp", tsURL.Host)
+	if err != nil {
+		t.Fatalf("dialing proxy: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", destAddr, destAddr)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("reading CONNECT response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %q, want 200 Connection Established", resp.Status)
+	}
+
+	fmt.Fprint(conn, "a tiny request\n")
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		t.Fatalf("conn is %T, want *net.TCPConn", conn)
+	}
+	if err := tcpConn.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatalf("reading tunneled response: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("tunneled response = %d bytes, want %d bytes (truncated by early close on half-close)", len(got), len(want))
+	}
+}
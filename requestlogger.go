@@ -1,4 +1,4 @@
-// Copyright 2019 The Alpaca Authors
+// Copyright 2019, 2025 The Alpaca Authors
 //
 // Licensed under the Apache License, Version 2.0 (the "License");
 // you may not use this file except in compliance with the License.
@@ -16,7 +16,6 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net"
@@ -27,7 +26,8 @@ import (
 
 type statusWriter struct {
 	http.ResponseWriter
-	status int
+	status  int
+	written int64
 }
 
 func (w *statusWriter) WriteHeader(status int) {
@@ -35,6 +35,12 @@ func (w *statusWriter) WriteHeader(status int) {
 	w.ResponseWriter.WriteHeader(status)
 }
 
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
 func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
 		return h.Hijack()
@@ -42,19 +48,75 @@ func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return nil, nil, fmt.Errorf("upstream ResponseWriter does not implement http.Hijacker")
 }
 
+// requestMetrics accumulates the per-request counters that are only known
+// deep inside the proxying path (the auth method that ended up being used,
+// request body bytes sent upstream for a forwarded request, and bytes
+// tunneled for CONNECT requests). It's attached to the request context by
+// AddContextID and mutated in place by the handlers that learn these
+// values, so that RequestLoggerJSON can read them once the request has
+// finished.
+type requestMetrics struct {
+	AuthMethod     string
+	RequestBytesIn int64
+	TunnelBytesIn  int64
+	TunnelBytesOut int64
+}
+
+const contextKeyMetrics contextKey = "metrics"
+
+// recordAuthMethod records which proxyAuthenticator ended up handling req,
+// if the request carries a *requestMetrics (see AddContextID).
+func recordAuthMethod(req *http.Request, method proxyAuthenticator) {
+	value := req.Context().Value(contextKeyMetrics)
+	if value == nil {
+		return
+	}
+	value.(*requestMetrics).AuthMethod = authMethodName(method)
+}
+
+// authMethodName returns a short, log-friendly name for a proxyAuthenticator
+// implementation.
+func authMethodName(a proxyAuthenticator) string {
+	switch a.(type) {
+	case *basicAuthenticator:
+		return "basic"
+	case *authenticator:
+		return "ntlm"
+	case *negotiateAuthenticator:
+		return "negotiate"
+	default:
+		return fmt.Sprintf("%T", a)
+	}
+}
+
+// jsonLogEntry is the structured record emitted for each proxied request.
+// It's shared by every LogSink implementation so that stdout, file,
+// syslog, and OTLP sinks all describe a request identically.
 type jsonLogEntry struct {
-	ID          uint64 `json:"id"`
-	Timestamp   string `json:"timestamp"`
-	Status      int    `json:"status"`
-	Method      string `json:"method"`
-	URL         string `json:"url"`
-	ParentProxy string `json:"parent_proxy"`
+	ID          uint64  `json:"id"`
+	Timestamp   string  `json:"timestamp"`
+	Status      int     `json:"status"`
+	Method      string  `json:"method"`
+	URL         string  `json:"url"`
+	ParentProxy string  `json:"parent_proxy"`
+	AuthMethod  string  `json:"proxy_auth_method,omitempty"`
+	DurationMs  float64 `json:"duration_ms"`
+	BytesIn     int64   `json:"bytes_in"`
+	BytesOut    int64   `json:"bytes_out"`
 }
 
-func RequestLoggerJSON(next http.Handler) http.Handler {
+// RequestLoggerJSON logs one jsonLogEntry per request to each of the given
+// sinks. With no sinks configured, it behaves as before and prints to
+// stdout.
+func RequestLoggerJSON(next http.Handler, sinks []LogSink) http.Handler {
+	if len(sinks) == 0 {
+		sinks = []LogSink{&stdoutSink{}}
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
 		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
 		next.ServeHTTP(sw, req)
+
 		var parentProxy string
 		if value := req.Context().Value(contextKeyProxy); value != nil {
 			parentProxy = value.(*url.URL).Host
@@ -63,22 +125,37 @@ func RequestLoggerJSON(next http.Handler) http.Handler {
 		if value := req.Context().Value(contextKeyID); value != nil {
 			id = value.(uint64)
 		}
+		var m *requestMetrics
+		if value := req.Context().Value(contextKeyMetrics); value != nil {
+			m = value.(*requestMetrics)
+		}
 		entry := jsonLogEntry{
 			ID:          id,
-			Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+			Timestamp:   start.UTC().Format(time.RFC3339Nano),
 			Status:      sw.status,
 			Method:      req.Method,
 			URL:         req.URL.String(),
 			ParentProxy: parentProxy,
+			DurationMs:  float64(time.Since(start)) / float64(time.Millisecond),
+			BytesOut:    sw.written,
+		}
+		if m != nil {
+			entry.AuthMethod = m.AuthMethod
+			entry.BytesIn = m.RequestBytesIn + m.TunnelBytesIn
+			if m.TunnelBytesOut > 0 {
+				entry.BytesOut += m.TunnelBytesOut
+			}
 		}
-		if data, err := json.Marshal(entry); err == nil {
-			fmt.Println(string(data))
+		for _, sink := range sinks {
+			sink.Log(entry)
 		}
+		appMetrics.recordRequest(entry.Method, entry.Status, entry.ParentProxy, entry.AuthMethod, entry.DurationMs/1000)
 	})
 }
 
 func RequestLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
 		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
 		next.ServeHTTP(sw, req)
 		log.Printf(
@@ -88,5 +165,14 @@ func RequestLogger(next http.Handler) http.Handler {
 			req.Method,
 			req.URL,
 		)
+		var authMethod string
+		if value := req.Context().Value(contextKeyMetrics); value != nil {
+			authMethod = value.(*requestMetrics).AuthMethod
+		}
+		var parentProxy string
+		if value := req.Context().Value(contextKeyProxy); value != nil {
+			parentProxy = value.(*url.URL).Host
+		}
+		appMetrics.recordRequest(req.Method, sw.status, parentProxy, authMethod, time.Since(start).Seconds())
 	})
 }